@@ -0,0 +1,62 @@
+// Package goseqtest provides test doubles for goseq's Transport
+// interface so callers can exercise MasterServer pagination, header
+// validation, and timeout handling without a real network.
+package goseqtest
+
+import (
+	"context"
+	"time"
+
+	"github.com/hfern/goseq"
+)
+
+// MemoryTransport is a goseq.Transport that serves a fixed, ordered
+// list of canned responses instead of talking to a real master
+// server. Each RoundTrip call consumes the next entry in Responses;
+// a nil entry is treated as a timeout (goseq.ErrTimeout), and running
+// past the end of Responses is also reported as a timeout so a test
+// exercising the "master stopped responding" path doesn't need a
+// sentinel of its own.
+type MemoryTransport struct {
+	// Responses are returned in order, one per RoundTrip call,
+	// regardless of which address SetAddr last pointed at.
+	Responses [][]byte
+
+	addr string
+	next int
+}
+
+// NewMemoryTransport returns a MemoryTransport that replays responses
+// in order.
+func NewMemoryTransport(responses [][]byte) *MemoryTransport {
+	return &MemoryTransport{Responses: responses}
+}
+
+func (t *MemoryTransport) SetAddr(addr string) error {
+	t.addr = addr
+	return nil
+}
+
+// Addr returns the address most recently passed to SetAddr, so a test
+// can assert that a MasterServer rotated to the next master server
+// after exhausting its retry policy.
+func (t *MemoryTransport) Addr() string { return t.addr }
+
+func (t *MemoryTransport) RoundTrip(ctx context.Context, timeout time.Duration, req []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if t.next >= len(t.Responses) {
+		return nil, goseq.ErrTimeout
+	}
+
+	resp := t.Responses[t.next]
+	t.next++
+
+	if resp == nil {
+		return nil, goseq.ErrTimeout
+	}
+
+	return resp, nil
+}
@@ -2,11 +2,11 @@ package goseq
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"io"
-	"net"
+	"net/netip"
 	"time"
 )
 
@@ -50,11 +50,37 @@ var (
 	// which server we're going to use by default
 	favored_server int = 2
 
-	MasterServerTimeout time.Duration = 5 * time.Second
+	// DefaultRetryPolicy is the RetryPolicy a new MasterServer is
+	// configured with. It follows the BEP-15 style backoff used for
+	// other Source/BitTorrent UDP request/response protocols: start
+	// at 15s, double on every contiguous timeout up to 4 times (15s,
+	// 30s, 60s, 120s, 240s), and give up on this master server after
+	// 6 attempts — one attempt past the point the timeout tops out.
+	DefaultRetryPolicy RetryPolicy = RetryPolicy{
+		Base:         15 * time.Second,
+		MaxDoublings: 4,
+		MaxAttempts:  6,
+	}
 
 	err_timeout error = errors.New("Couldn't read from master server.")
 )
 
+// RetryPolicy controls how a MasterServer backs off and eventually
+// gives up on a single master server before rotating to the next
+// entry in MasterSourceServers.
+type RetryPolicy struct {
+	// Base is the read timeout used for the first attempt against a
+	// master server.
+	Base time.Duration
+	// MaxDoublings caps how many times Base is doubled; once reached,
+	// the timeout stops growing for any remaining attempts.
+	MaxDoublings int
+	// MaxAttempts is the number of backed-off attempts made against a
+	// single master server before falling over to the next one in
+	// MasterSourceServers.
+	MaxAttempts int
+}
+
 var (
 	masterResponseHeader [masterRespHeaderLength]byte = [...]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x66, 0x0A}
 )
@@ -70,6 +96,46 @@ type MasterServer interface {
 	// with startIP.
 	// Returned servers are NOT guaranteed to work.
 	Query(startIP string) ([]Server, error)
+	// QueryContext is like Query, but aborts the in-flight request
+	// as soon as ctx is cancelled instead of waiting out the full
+	// master server timeout.
+	QueryContext(ctx context.Context, startIP string) ([]Server, error)
+	// Iterate walks the entire master listing, automatically
+	// paginating with the last IP of each batch, and streams the
+	// (de-duplicated) results until the master signals the end of
+	// the listing with the 0.0.0.0:0 terminator or ctx is cancelled.
+	// A page that fails is sent as a single Err item and ends the
+	// walk; a page with no servers ends it too.
+	Iterate(ctx context.Context) <-chan ServerOrError
+	// SetRetryPolicy overrides the backoff used for UDP request/
+	// response attempts against a single master server.
+	SetRetryPolicy(RetryPolicy)
+	GetRetryPolicy() RetryPolicy
+}
+
+// NewMasterServerWithTransport is like NewMasterServer, but lets the
+// caller supply the Transport requests are sent over — a mocked
+// transport for tests, one that runs over a SOCKS proxy, or a
+// captured-packet replay for regression tests.
+func NewMasterServerWithTransport(t Transport) MasterServer {
+	m := &master{
+		filter:       NewFilter(),
+		addr:         MasterSourceServers[favored_server],
+		master_index: favored_server,
+		region:       USWest,
+		retry:        DefaultRetryPolicy,
+		transport:    t,
+	}
+	m.transport.SetAddr(m.addr)
+	return m
+}
+
+// ServerOrError is a single item from a master server Iterate stream:
+// either a Server that hasn't been seen yet, or a transport error that
+// occurred while fetching a page.
+type ServerOrError struct {
+	Server Server
+	Err    error
 }
 
 type master struct {
@@ -77,43 +143,22 @@ type master struct {
 	addr         string
 	master_index int
 	region       Region
-	remoteAddr   *net.UDPAddr
-	remoteConn   *net.UDPConn
+	retry        RetryPolicy
+	transport    Transport
 }
 
 func NewMasterServer() MasterServer {
-	return &master{
-		filter:       NewFilter(),
-		addr:         MasterSourceServers[favored_server],
-		master_index: favored_server,
-		region:       USWest,
-		remoteAddr:   nil,
-		remoteConn:   nil,
-	}
+	return NewMasterServerWithTransport(NewUDPTransport())
 }
 
-func (m *master) SetFilter(f Filter) error { m.filter = f; return nil }
-func (m *master) GetFilter() Filter        { return m.filter }
-func (m *master) SetAddr(i string) error   { m.addr = i; m.remoteAddr = nil; return nil }
-func (m *master) GetAddr() string          { return m.addr }
-func (m *master) SetRegion(i Region)       { m.region = i }
-func (m *master) GetRegion() Region        { return m.region }
-
-func (m *master) refreshConnection() (err error) {
-	if m.remoteAddr == nil || m.remoteConn == nil {
-		m.remoteAddr, err = net.ResolveUDPAddr("udp", m.addr)
-		if err != nil {
-			m.remoteAddr = nil
-			return err
-		}
-		m.remoteConn, err = net.DialUDP("udp", nil, m.remoteAddr)
-		if err != nil {
-			m.remoteAddr = nil
-			return err
-		}
-	}
-	return nil
-}
+func (m *master) SetFilter(f Filter) error     { m.filter = f; return nil }
+func (m *master) GetFilter() Filter            { return m.filter }
+func (m *master) SetAddr(i string) error       { m.addr = i; return m.transport.SetAddr(i) }
+func (m *master) GetAddr() string              { return m.addr }
+func (m *master) SetRegion(i Region)           { m.region = i }
+func (m *master) GetRegion() Region            { return m.region }
+func (m *master) SetRetryPolicy(p RetryPolicy) { m.retry = p }
+func (m *master) GetRetryPolicy() RetryPolicy  { return m.retry }
 
 func (m *master) makerequest(ip string) []byte {
 	packet := bytes.NewBuffer([]byte{})
@@ -127,123 +172,249 @@ func (m *master) makerequest(ip string) []byte {
 	return req
 }
 
-// performs no allocations to keep it fast
-// iterating over hundreds of servers.
-func (_ *master) ip2server(ip wireIP, serv *iserver) {
-	serv.addr = ip.String()
+// try sends request over m.transport and waits for a single reply,
+// honoring both timeout and ctx cancellation.
+func (m *master) try(ctx context.Context, timeout time.Duration, request []byte) ([]byte, error) {
+	return m.transport.RoundTrip(ctx, timeout, request)
 }
 
-func (m *master) try(request, buffer []byte) (error, int) {
-	timeout := make(chan bool, 1)
-	done := make(chan error, 1)
-	n := 0
-	var e error
-
-	go func() {
-		if e := m.refreshConnection(); e != nil {
-			done <- e
-			return
-		}
-
-		if _, e := m.remoteConn.Write(request); e != nil {
-			done <- e
-			return
-		}
-
-		n, e = m.remoteConn.Read(buffer)
-		if e != nil {
-			done <- e
-			return
-		}
-		done <- nil
-	}()
-
-	go func() {
-		time.Sleep(MasterServerTimeout)
-		timeout <- true
-	}()
+func (m *master) Query(at string) ([]Server, error) {
+	return m.QueryContext(context.Background(), at)
+}
 
-	select {
-	case e := <-done:
-		return e, n
-	case <-timeout:
-		return err_timeout, 0
-	}
+func (m *master) QueryContext(ctx context.Context, at string) ([]Server, error) {
+	servers, _, err := m.queryPage(ctx, at)
+	return servers, err
 }
 
-func (m *master) Query(at string) ([]Server, error) {
+// queryPage is the shared implementation behind QueryContext and
+// Iterate. Unlike QueryContext, it also returns whether the master
+// signalled the end of the listing, which Iterate needs in order to
+// know when to stop paginating — information that's otherwise lost
+// once the 0.0.0.0:0 terminator is stripped out of servers.
+func (m *master) queryPage(ctx context.Context, at string) ([]Server, bool, error) {
 	reqpacket := m.makerequest(at)
-	respbuffer := [1024 * 1024 * 2]byte{} // 2MB, should be 400 bytes above max
 
 	var e error
-	var n int
+	var respBytes []byte
 
 	start_indice := m.master_index
 	for {
-		e, n = m.try(reqpacket, respbuffer[0:])
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+
+		timeout := m.retry.Base
+		for attempt := 0; attempt < m.retry.MaxAttempts; attempt++ {
+			if ctx.Err() != nil {
+				return nil, false, ctx.Err()
+			}
+
+			respBytes, e = m.try(ctx, timeout, reqpacket)
+			if e != err_timeout {
+				break
+			}
+
+			if attempt < m.retry.MaxDoublings {
+				timeout *= 2
+			}
+		}
+
 		if e == err_timeout {
 			m.master_index = (m.master_index + 1) % len(MasterSourceServers)
 			m.addr = MasterSourceServers[m.master_index]
 
 			if m.master_index == start_indice {
 				// we've come full circle, time to quit.
-				return nil, err_timeout
+				m.transport.SetAddr(m.addr)
+				return nil, false, err_timeout
 			}
 
 			favored_server = m.master_index
-			m.remoteAddr = nil
+			m.transport.SetAddr(m.addr)
 		} else if e != nil {
-			return nil, e
+			return nil, false, e
 		} else {
 			break
 		}
 	}
 
 	resp := wireMasterResponse{}
-	err := resp.Decode(bytes.NewBuffer(respbuffer[0:n]))
+	err := resp.Decode(bytes.NewBuffer(respBytes))
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	servers := make([]Server, len(resp.ips))
+	servers := make([]Server, 0, len(resp.addrs))
 
-	for i, ip := range resp.ips {
-		servers[i] = iserver{addr: ip.String()}
+	for _, addr := range resp.addrs {
+		// The 0.0.0.0:0 terminator is how the decode layer knows the
+		// listing ended; it's not a real server, so callers paginating
+		// manually via startIP should never see it. resp.done carries
+		// the same fact for Iterate, which needs it to know when to
+		// stop.
+		if addr == terminator {
+			continue
+		}
+		servers = append(servers, iserver{addr: addr.AddrPort})
 	}
 
-	return servers, nil
+	return servers, resp.done, nil
 }
 
-// Incoming IPs as represented on the wire.
-type wireIP struct {
-	oct struct {
-		o1,
-		o2,
-		o3,
-		o4 byte
-	}
-	// ATCHTUNG!!! This is NETWORK BYTE ORDERED
-	// as defined by the spec.
-	port uint16
+// Iterate walks the entire master listing, automatically paginating
+// with the last IP of each batch, and streams de-duplicated servers
+// on the returned channel until the master returns the 0.0.0.0:0
+// terminator or ctx is cancelled. An error fetching a page (including
+// every master server timing out) is sent as one ServerOrError with
+// Err set, after which the walk stops; it is not retried. A page with
+// no servers also stops the walk. The channel is closed when the walk
+// ends for any reason.
+func (m *master) Iterate(ctx context.Context) <-chan ServerOrError {
+	out := make(chan ServerOrError)
+
+	go func() {
+		defer close(out)
+
+		seen := make(map[string]struct{})
+		startIP := Beggining
+
+		for {
+			servers, done, err := m.queryPage(ctx, startIP)
+			if err != nil {
+				select {
+				case out <- ServerOrError{Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+
+			if len(servers) == 0 {
+				return
+			}
+
+			for _, s := range servers {
+				addr := s.GetAddr()
+				if _, dup := seen[addr]; dup {
+					continue
+				}
+				seen[addr] = struct{}{}
+
+				select {
+				case out <- ServerOrError{Server: s}:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if done {
+				return
+			}
+			startIP = servers[len(servers)-1].GetAddr()
+		}
+	}()
+
+	return out
+}
+
+// wireAddr is an address/port pair as represented on the wire. The
+// master protocol sends plain 6-byte IPv4 records only; decodeWireAddr
+// also knows how to read an 18-byte IPv6 record, but nothing in this
+// package calls it with v6 set to true yet, since the master protocol
+// has no family byte of its own to drive that dispatch off of and no
+// other decode path in this tree produces dual-stack addresses. It's
+// kept as infrastructure for whichever of those shows up next, not as
+// a currently reachable feature.
+type wireAddr struct {
+	netip.AddrPort
 }
 
-func (p wireIP) String() string {
-	return fmt.Sprintf("%d.%d.%d.%d:%d",
-		p.oct.o1, p.oct.o2, p.oct.o3, p.oct.o4, p.port)
+func (a wireAddr) String() string { return a.AddrPort.String() }
+
+// terminator is the 0.0.0.0:0 record the master uses to mark the end
+// of a listing.
+var terminator = wireAddr{netip.MustParseAddrPort(Beggining)}
+
+// decodeWireAddr reads a single address record from r: a plain 6-byte
+// IPv4+port record, or, when v6 is true, an 18-byte IPv6+port record.
+// v6 must come from something that actually identifies the record's
+// shape (a protocol-level family byte, a field known in advance to be
+// IPv6-only, ...) — a record's length alone is not a safe way to
+// guess this: a run of ordinary IPv4 records can just as easily leave
+// exactly 18 bytes remaining.
+func decodeWireAddr(r io.Reader, v6 bool) (wireAddr, error) {
+	if v6 {
+		var raw [16]byte
+		if _, err := io.ReadFull(r, raw[:]); err != nil {
+			return wireAddr{}, err
+		}
+		var port uint16
+		if err := binary.Read(r, binary.BigEndian, &port); err != nil {
+			return wireAddr{}, err
+		}
+		return wireAddr{netip.AddrPortFrom(netip.AddrFrom16(raw), port)}, nil
+	}
+
+	var oct [4]byte
+	if _, err := io.ReadFull(r, oct[:]); err != nil {
+		return wireAddr{}, err
+	}
+	// The port is transmitted in network byte order regardless of
+	// byteOrder, which governs the rest of the protocol.
+	var port uint16
+	if err := binary.Read(r, binary.BigEndian, &port); err != nil {
+		return wireAddr{}, err
+	}
+	return wireAddr{netip.AddrPortFrom(netip.AddrFrom4(oct), port)}, nil
 }
 
 type wireMasterResponse struct {
 	head struct {
 		magic [masterRespHeaderLength]byte
 	}
-	ips []wireIP
+	addrs []wireAddr
+	// done is true once a 0.0.0.0:0 terminator record has been read,
+	// meaning the caller has reached the end of the listing.
+	done bool
 }
 
+// ErrBadMasterHeader is returned by wireMasterResponse.Decode when a
+// packet's header doesn't match masterResponseHeader, which usually
+// means we read something other than a master server list response.
+var ErrBadMasterHeader error = errors.New("goseq: master response header did not match the expected magic")
+
 func (r *wireMasterResponse) Decode(packet io.Reader) error {
-	err := binary.Read(packet, byteOrder, &r.head)
-	if err != nil {
+	// Read directly into a local array rather than binary.Read-ing
+	// into r.head: binary.Read sets fields via reflection, and
+	// reflect refuses to Set an unexported struct field (magic) even
+	// from within this package, which panics on every call.
+	var magic [masterRespHeaderLength]byte
+	if _, err := io.ReadFull(packet, magic[:]); err != nil {
 		return err
 	}
+	r.head.magic = magic
+
+	if r.head.magic != masterResponseHeader {
+		return ErrBadMasterHeader
+	}
+
+	// The master protocol only ever sends 6-byte IPv4 records.
+	for {
+		addr, err := decodeWireAddr(packet, false)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		r.addrs = append(r.addrs, addr)
+
+		if addr == terminator {
+			r.done = true
+			break
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,104 @@
+package goseq
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWireMasterResponseDecode(t *testing.T) {
+	cases := []struct {
+		name     string
+		packet   []byte
+		wantIPs  []string
+		wantDone bool
+		wantErr  error
+	}{
+		{
+			name: "single server then terminator",
+			packet: append(
+				append([]byte{}, masterResponseHeader[:]...),
+				// 1.2.3.4:27015
+				0x01, 0x02, 0x03, 0x04, 0x69, 0x87,
+				// 0.0.0.0:0 terminator
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			),
+			wantIPs:  []string{"1.2.3.4:27015", "0.0.0.0:0"},
+			wantDone: true,
+		},
+		{
+			name:    "bad header",
+			packet:  []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			wantErr: ErrBadMasterHeader,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var resp wireMasterResponse
+			err := resp.Decode(bytes.NewReader(c.packet))
+
+			if c.wantErr != nil {
+				if err != c.wantErr {
+					t.Fatalf("Decode() error = %v, want %v", err, c.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Decode() unexpected error: %v", err)
+			}
+
+			got := make([]string, len(resp.addrs))
+			for i, addr := range resp.addrs {
+				got[i] = addr.String()
+			}
+
+			if len(got) != len(c.wantIPs) {
+				t.Fatalf("got %d ips, want %d (%v vs %v)", len(got), len(c.wantIPs), got, c.wantIPs)
+			}
+			for i := range got {
+				if got[i] != c.wantIPs[i] {
+					t.Fatalf("ip[%d] = %q, want %q", i, got[i], c.wantIPs[i])
+				}
+			}
+
+			if resp.done != c.wantDone {
+				t.Fatalf("resp.done = %v, want %v", resp.done, c.wantDone)
+			}
+		})
+	}
+}
+
+func TestDecodeWireAddr(t *testing.T) {
+	cases := []struct {
+		name  string
+		v6    bool
+		input []byte
+		want  string
+	}{
+		{
+			name:  "ipv4",
+			v6:    false,
+			input: []byte{0x01, 0x02, 0x03, 0x04, 0x69, 0x87},
+			want:  "1.2.3.4:27015",
+		},
+		{
+			name:  "ipv6",
+			v6:    true,
+			input: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x69, 0x87},
+			want:  "[::1]:27015",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addr, err := decodeWireAddr(bytes.NewReader(c.input), c.v6)
+			if err != nil {
+				t.Fatalf("decodeWireAddr() unexpected error: %v", err)
+			}
+			if got := addr.String(); got != c.want {
+				t.Fatalf("decodeWireAddr() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package goseq
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	a2sInfoQuery      byte = 0x54
+	a2sInfoReply      byte = 0x49
+	a2sChallengeReply byte = 0x41
+)
+
+// byteOrder is the multi-byte field encoding the Source engine query
+// protocol uses (everything except the port, which decodeWireAddr
+// reads separately as network byte order).
+var byteOrder binary.ByteOrder = binary.LittleEndian
+
+var a2sHeader = [4]byte{0xFF, 0xFF, 0xFF, 0xFF}
+
+// InfoResponse is the decoded payload of an A2S_INFO reply.
+type InfoResponse struct {
+	Protocol    byte
+	Name        string
+	Map         string
+	Folder      string
+	Game        string
+	ID          int16
+	Players     byte
+	MaxPlayers  byte
+	Bots        byte
+	ServerType  byte
+	Environment byte
+	Visibility  byte
+	VAC         byte
+}
+
+// QueryAllOptions configures the worker pool QueryAll fans A2S_INFO
+// queries out over.
+type QueryAllOptions struct {
+	// Workers is how many goroutines concurrently issue A2S_INFO
+	// queries, each owning its own UDP socket. Defaults to 64 when
+	// <= 0.
+	Workers int
+	// PerServerTimeout bounds a single server's A2S_INFO round trip,
+	// including the challenge re-query. Defaults to 3s when <= 0.
+	PerServerTimeout time.Duration
+	// RatePerSecond caps how many new queries are started per second
+	// across the whole pool. 0 means unlimited.
+	RatePerSecond int
+}
+
+// ServerInfoResult is one QueryAll outcome.
+type ServerInfoResult struct {
+	Server Server
+	Info   *InfoResponse
+	Err    error
+}
+
+// QueryAll fans an A2S_INFO query out to every server in servers using
+// a bounded worker pool instead of one goroutine per server, which is
+// what drives users into "too many open sockets / i/o timeout"
+// failures. Results are streamed on the returned channel as they
+// arrive; it is closed once every server has been queried or ctx is
+// cancelled.
+func QueryAll(ctx context.Context, servers []Server, opts QueryAllOptions) <-chan ServerInfoResult {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 64
+	}
+	perServerTimeout := opts.PerServerTimeout
+	if perServerTimeout <= 0 {
+		perServerTimeout = 3 * time.Second
+	}
+
+	var limiter *time.Ticker
+	if opts.RatePerSecond > 0 {
+		limiter = time.NewTicker(time.Second / time.Duration(opts.RatePerSecond))
+	}
+
+	jobs := make(chan Server)
+	results := make(chan ServerInfoResult)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for s := range jobs {
+				if limiter != nil {
+					select {
+					case <-limiter.C:
+					case <-ctx.Done():
+						return
+					}
+				}
+
+				info, err := queryInfo(ctx, s.GetAddr(), perServerTimeout)
+
+				select {
+				case results <- ServerInfoResult{Server: s, Info: info, Err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, s := range servers {
+			select {
+			case jobs <- s:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		if limiter != nil {
+			limiter.Stop()
+		}
+		close(results)
+	}()
+
+	return results
+}
+
+// infoReply is either a fresh challenge to retry the request with, or
+// a fully decoded A2S_INFO payload.
+type infoReply struct {
+	info      *InfoResponse
+	challenge []byte
+}
+
+// queryInfo performs a single A2S_INFO handshake, including the
+// challenge round trip modern servers require, owning its own UDP
+// socket for the duration of the call.
+func queryInfo(ctx context.Context, addr string, timeout time.Duration) (*InfoResponse, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	reply, err := doInfoRequest(conn, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if reply.challenge != nil {
+		reply, err = doInfoRequest(conn, reply.challenge)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if reply.info == nil {
+		return nil, errors.New("goseq: server never replied with an A2S_INFO payload")
+	}
+
+	return reply.info, nil
+}
+
+func doInfoRequest(conn *net.UDPConn, challenge []byte) (infoReply, error) {
+	req := bytes.NewBuffer([]byte{})
+	req.Write(a2sHeader[:])
+	req.WriteByte(a2sInfoQuery)
+	req.WriteString("Source Engine Query")
+	req.WriteByte(0x0)
+	if challenge != nil {
+		req.Write(challenge)
+	}
+
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return infoReply{}, err
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return infoReply{}, err
+	}
+
+	r := bytes.NewReader(buf[:n])
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return infoReply{}, err
+	}
+	if head[0] != 0xFF || head[1] != 0xFF || head[2] != 0xFF || head[3] != 0xFF {
+		return infoReply{}, errors.New("goseq: malformed A2S_INFO reply header")
+	}
+
+	switch head[4] {
+	case a2sChallengeReply:
+		ch := make([]byte, 4)
+		if _, err := io.ReadFull(r, ch); err != nil {
+			return infoReply{}, err
+		}
+		return infoReply{challenge: ch}, nil
+	case a2sInfoReply:
+		info, err := decodeInfoResponse(r)
+		if err != nil {
+			return infoReply{}, err
+		}
+		return infoReply{info: info}, nil
+	default:
+		return infoReply{}, fmt.Errorf("goseq: unexpected A2S_INFO reply type 0x%x", head[4])
+	}
+}
+
+func decodeInfoResponse(r *bytes.Reader) (*InfoResponse, error) {
+	info := &InfoResponse{}
+
+	var err error
+	if info.Protocol, err = r.ReadByte(); err != nil {
+		return nil, err
+	}
+	if info.Name, err = readCString(r); err != nil {
+		return nil, err
+	}
+	if info.Map, err = readCString(r); err != nil {
+		return nil, err
+	}
+	if info.Folder, err = readCString(r); err != nil {
+		return nil, err
+	}
+	if info.Game, err = readCString(r); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, byteOrder, &info.ID); err != nil {
+		return nil, err
+	}
+	if info.Players, err = r.ReadByte(); err != nil {
+		return nil, err
+	}
+	if info.MaxPlayers, err = r.ReadByte(); err != nil {
+		return nil, err
+	}
+	if info.Bots, err = r.ReadByte(); err != nil {
+		return nil, err
+	}
+	if info.ServerType, err = r.ReadByte(); err != nil {
+		return nil, err
+	}
+	if info.Environment, err = r.ReadByte(); err != nil {
+		return nil, err
+	}
+	if info.Visibility, err = r.ReadByte(); err != nil {
+		return nil, err
+	}
+	if info.VAC, err = r.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func readCString(r *bytes.Reader) (string, error) {
+	var b bytes.Buffer
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if c == 0x0 {
+			break
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,156 @@
+package goseq_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hfern/goseq"
+)
+
+// fakeServer is a Server implementation that points at an address the
+// test controls directly, so QueryAll can be pointed at a fake UDP
+// A2S server instead of a real game server.
+type fakeServer struct{ addr string }
+
+func (s fakeServer) GetAddr() string             { return s.addr }
+func (s fakeServer) GetAddrPort() netip.AddrPort { return netip.MustParseAddrPort(s.addr) }
+
+// startFakeA2SServer starts a real, local UDP listener that plays the
+// role of a Source engine game server: respond is handed every packet
+// it receives and a callback to send a reply back to the requester,
+// so a test can script the A2S_INFO handshake (challenge, then full
+// reply) without a real game server.
+func startFakeA2SServer(t *testing.T, respond func(pkt []byte, reply func([]byte))) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			pkt := append([]byte{}, buf[:n]...)
+			respond(pkt, func(resp []byte) {
+				conn.WriteToUDP(resp, from)
+			})
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+var a2sHeaderBytes = []byte{0xFF, 0xFF, 0xFF, 0xFF}
+
+func challengeReplyPacket(challenge []byte) []byte {
+	pkt := append([]byte{}, a2sHeaderBytes...)
+	pkt = append(pkt, 0x41) // a2sChallengeReply
+	pkt = append(pkt, challenge...)
+	return pkt
+}
+
+func infoReplyPacket(info goseq.InfoResponse) []byte {
+	pkt := append([]byte{}, a2sHeaderBytes...)
+	pkt = append(pkt, 0x49) // a2sInfoReply
+	pkt = append(pkt, info.Protocol)
+	pkt = append(pkt, []byte(info.Name)...)
+	pkt = append(pkt, 0x0)
+	pkt = append(pkt, []byte(info.Map)...)
+	pkt = append(pkt, 0x0)
+	pkt = append(pkt, []byte(info.Folder)...)
+	pkt = append(pkt, 0x0)
+	pkt = append(pkt, []byte(info.Game)...)
+	pkt = append(pkt, 0x0)
+	id := make([]byte, 2)
+	binary.LittleEndian.PutUint16(id, uint16(info.ID))
+	pkt = append(pkt, id...)
+	pkt = append(pkt, info.Players, info.MaxPlayers, info.Bots, info.ServerType, info.Environment, info.Visibility, info.VAC)
+	return pkt
+}
+
+func TestQueryAllChallengeFlow(t *testing.T) {
+	want := goseq.InfoResponse{
+		Protocol: 17, Name: "Test Server", Map: "de_dust2", Folder: "cstrike", Game: "Counter-Strike",
+		ID: 10, Players: 5, MaxPlayers: 10, Bots: 0, ServerType: 'd', Environment: 'l', Visibility: 0, VAC: 1,
+	}
+	challenge := []byte{0x01, 0x02, 0x03, 0x04}
+
+	var queries atomic.Int32
+	addr := startFakeA2SServer(t, func(pkt []byte, reply func([]byte)) {
+		if queries.Add(1) == 1 {
+			// first request carries no challenge; issue one
+			reply(challengeReplyPacket(challenge))
+			return
+		}
+		reply(infoReplyPacket(want))
+	})
+
+	servers := []goseq.Server{fakeServer{addr: addr}}
+
+	results := goseq.QueryAll(context.Background(), servers, goseq.QueryAllOptions{
+		Workers:          1,
+		PerServerTimeout: time.Second,
+	})
+
+	var got []goseq.ServerInfoResult
+	for r := range results {
+		got = append(got, r)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("got %d results, want 1", len(got))
+	}
+	if got[0].Err != nil {
+		t.Fatalf("unexpected error: %v", got[0].Err)
+	}
+	if *got[0].Info != want {
+		t.Fatalf("got %+v, want %+v", *got[0].Info, want)
+	}
+	if n := queries.Load(); n != 2 {
+		t.Fatalf("server saw %d queries, want 2 (initial + post-challenge)", n)
+	}
+}
+
+func TestQueryAllTimeout(t *testing.T) {
+	// A listener that stays open but never reads or replies: every
+	// query sent to it goes unanswered, so QueryAll has to give up
+	// once PerServerTimeout runs out.
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	addr := conn.LocalAddr().String()
+
+	servers := []goseq.Server{fakeServer{addr: addr}}
+
+	start := time.Now()
+	results := goseq.QueryAll(context.Background(), servers, goseq.QueryAllOptions{
+		Workers:          1,
+		PerServerTimeout: 100 * time.Millisecond,
+	})
+
+	var got []goseq.ServerInfoResult
+	for r := range results {
+		got = append(got, r)
+	}
+	elapsed := time.Since(start)
+
+	if elapsed > 2*time.Second {
+		t.Fatalf("QueryAll took too long to give up: %v", elapsed)
+	}
+	if len(got) != 1 || got[0].Err == nil {
+		t.Fatalf("got %+v, want a single timeout error result", got)
+	}
+}
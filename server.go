@@ -0,0 +1,25 @@
+package goseq
+
+import "net/netip"
+
+// Server is a single game server as returned by a master listing.
+type Server interface {
+	// GetAddr returns the server's address as host:port text, e.g.
+	// "1.2.3.4:27015" or "[::1]:27015".
+	GetAddr() string
+	// GetAddrPort returns the same address as a netip.AddrPort, so
+	// callers that need the parsed host/port (rate limiting by
+	// address, dialing, comparing against a block list) don't have to
+	// re-parse GetAddr()'s text.
+	GetAddrPort() netip.AddrPort
+}
+
+// iserver is the default Server implementation produced by the master
+// package: just a typed address, stored as netip.AddrPort rather than
+// a pre-formatted string.
+type iserver struct {
+	addr netip.AddrPort
+}
+
+func (s iserver) GetAddr() string             { return s.addr.String() }
+func (s iserver) GetAddrPort() netip.AddrPort { return s.addr }
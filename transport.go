@@ -0,0 +1,105 @@
+package goseq
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// ErrTimeout is the error a Transport must return from RoundTrip when
+// it gives up waiting for a reply, so a MasterServer's retry/backoff
+// loop can tell a timeout apart from a hard transport failure.
+var ErrTimeout error = err_timeout
+
+// Transport abstracts the request/response round trip a MasterServer
+// makes against a single master server address, so Query/QueryContext
+// can be exercised without a real UDP socket (mocked transports,
+// SOCKS-proxied connections, captured-packet replay, ...).
+type Transport interface {
+	// SetAddr points the transport at a new master server address.
+	// It's called whenever the caller rotates through
+	// MasterSourceServers or calls MasterServer.SetAddr.
+	SetAddr(addr string) error
+	// RoundTrip writes req and returns the next reply, honoring
+	// timeout and ctx cancellation. A timeout must be reported as
+	// ErrTimeout so the caller's retry/backoff loop can tell it apart
+	// from a hard failure.
+	RoundTrip(ctx context.Context, timeout time.Duration, req []byte) ([]byte, error)
+}
+
+// UDPTransport is the default Transport, talking to a master server
+// over real UDP sockets.
+type UDPTransport struct {
+	addr string
+}
+
+// NewUDPTransport returns a Transport that dials a real UDP socket.
+func NewUDPTransport() *UDPTransport {
+	return &UDPTransport{}
+}
+
+func (t *UDPTransport) SetAddr(addr string) error {
+	t.addr = addr
+	return nil
+}
+
+func (t *UDPTransport) RoundTrip(ctx context.Context, timeout time.Duration, req []byte) ([]byte, error) {
+	raddr, err := net.ResolveUDPAddr("udp", t.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dial a fresh socket for every attempt rather than reusing one
+	// across retries. The master wire protocol carries no transaction
+	// id, so a reply can't be matched against the request that caused
+	// it; the previous approach (draining whatever sat in the socket
+	// buffer right before writing) only narrowed the window in which a
+	// late reply to a timed-out attempt could be mistaken for the
+	// answer to this one, it didn't close it. A new ephemeral local
+	// port per attempt does: once the old socket is closed, a reply
+	// addressed to its port is no longer deliverable to anything we're
+	// reading from, so it can't land in the new attempt's Read.
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	// unblock ensures the deadline watcher below exits as soon as the
+	// read returns, rather than leaking a goroutine per attempt.
+	unblock := make(chan struct{})
+	defer close(unblock)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-unblock:
+		}
+	}()
+
+	buf := make([]byte, 1024*1024*2) // 2MB, should be 400 bytes above max
+	n, err := conn.Read(buf)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, ErrTimeout
+		}
+		return nil, err
+	}
+
+	return buf[:n], nil
+}
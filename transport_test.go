@@ -0,0 +1,59 @@
+package goseq_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hfern/goseq"
+	"github.com/hfern/goseq/goseqtest"
+)
+
+var validMasterHeader = []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x66, 0x0A}
+
+func TestMasterServerIteratePagination(t *testing.T) {
+	transport := goseqtest.NewMemoryTransport([][]byte{
+		// page 1: a single, not-yet-terminal server
+		append(append([]byte{}, validMasterHeader...), 0x01, 0x02, 0x03, 0x04, 0x69, 0x87),
+		// page 2: the terminator, ending the listing
+		append(append([]byte{}, validMasterHeader...), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00),
+	})
+
+	m := goseq.NewMasterServerWithTransport(transport)
+
+	var got []string
+	for item := range m.Iterate(context.Background()) {
+		if item.Err != nil {
+			t.Fatalf("unexpected error from Iterate: %v", item.Err)
+		}
+		got = append(got, item.Server.GetAddr())
+	}
+
+	if len(got) != 1 || got[0] != "1.2.3.4:27015" {
+		t.Fatalf("got %v, want [1.2.3.4:27015]", got)
+	}
+}
+
+func TestMasterServerQueryBadHeader(t *testing.T) {
+	transport := goseqtest.NewMemoryTransport([][]byte{
+		{0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	})
+
+	m := goseq.NewMasterServerWithTransport(transport)
+
+	_, err := m.Query(goseq.Beggining)
+	if err != goseq.ErrBadMasterHeader {
+		t.Fatalf("Query() error = %v, want ErrBadMasterHeader", err)
+	}
+}
+
+func TestMasterServerQueryExhaustsRetryPolicy(t *testing.T) {
+	transport := goseqtest.NewMemoryTransport(nil)
+
+	m := goseq.NewMasterServerWithTransport(transport)
+	m.SetRetryPolicy(goseq.RetryPolicy{Base: 0, MaxDoublings: 0, MaxAttempts: 1})
+
+	_, err := m.Query(goseq.Beggining)
+	if err != goseq.ErrTimeout {
+		t.Fatalf("Query() error = %v, want ErrTimeout", err)
+	}
+}